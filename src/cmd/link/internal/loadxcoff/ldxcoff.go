@@ -11,8 +11,12 @@ import (
 	"cmd/internal/sys"
 	"cmd/link/internal/sym"
 	"debug/xcoff"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"strconv"
+	"strings"
 )
 
 // Xcoff section with its symbol
@@ -21,6 +25,25 @@ type ldSection struct {
 	sym *sym.Symbol
 }
 
+// dwarfSubtypeToSym maps an XCOFF DWARF subsection subtype to the name
+// of the corresponding Go linker DWARF symbol.
+var dwarfSubtypeToSym = map[uint32]struct {
+	name string
+	kind sym.SymKind
+}{
+	xcoff.SSUBTYP_DWINFO:  {".debug_info", sym.SDWARFINFO},
+	xcoff.SSUBTYP_DWLINE:  {".debug_line", sym.SDWARFLINES},
+	xcoff.SSUBTYP_DWABREV: {".debug_abbrev", sym.SDWARFINFO},
+	xcoff.SSUBTYP_DWARNGE: {".debug_aranges", sym.SDWARFRANGE},
+	xcoff.SSUBTYP_DWRNGES: {".debug_ranges", sym.SDWARFRANGE},
+	xcoff.SSUBTYP_DWLOC:   {".debug_loc", sym.SDWARFLOC},
+	xcoff.SSUBTYP_DWFRAME: {".debug_frame", sym.SDWARFINFO},
+	xcoff.SSUBTYP_DWMAC:   {".debug_macinfo", sym.SDWARFINFO},
+	xcoff.SSUBTYP_DWSTR:   {".debug_str", sym.SDWARFINFO},
+	xcoff.SSUBTYP_DWPBNMS: {".debug_pubnames", sym.SDWARFINFO},
+	xcoff.SSUBTYP_DWPBTYP: {".debug_pubtypes", sym.SDWARFINFO},
+}
+
 // TODO(brainman): maybe just add ReadAt method to bio.Reader instead of creating xcoffBiobuf
 
 // xcoffBiobuf makes bio.Reader look like io.ReaderAt.
@@ -41,6 +64,21 @@ func (f *xcoffBiobuf) ReadAt(p []byte, off int64) (int, error) {
 // Load loads the Xcoff file pn from f.
 // Symbols are written into syms, and a slice of the text symbols is returned.
 func Load(arch *sys.Arch, syms *sym.Symbols, input *bio.Reader, pkg string, length int64, pn string) (textp []*sym.Symbol, err error) {
+	f, err := xcoff.NewFile((*xcoffBiobuf)(input))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return loadObject(arch, syms, f, pkg, pn)
+}
+
+// loadObject loads the XCOFF object f, which was read from the section
+// of the underlying archive or file named pn. Symbols are written into syms.
+// The symbols made for f's sections use a symbol version private to this
+// object, so identically named sections of different objects (e.g. different
+// big-archive members) don't collide. Symbols from f's symbol table, C_HIDEXT
+// ones included, are all looked up with version 0 and are shared across objects.
+func loadObject(arch *sys.Arch, syms *sym.Symbols, f *xcoff.File, pkg, pn string) (textp []*sym.Symbol, err error) {
 	errorf := func(str string, args ...interface{}) ([]*sym.Symbol, error) {
 		return nil, fmt.Errorf("loadxcoff: %v: %v", pn, fmt.Sprintf(str, args...))
 	}
@@ -48,31 +86,50 @@ func Load(arch *sys.Arch, syms *sym.Symbols, input *bio.Reader, pkg string, leng
 
 	var ldSections []*ldSection
 
-	f, err := xcoff.NewFile((*xcoffBiobuf)(input))
-	if err != nil {
-		return nil, err
+	switch f.TargetMachine {
+	default:
+		return errorf("unrecognized XCOFF target machine: 0x%x", f.TargetMachine)
+	case xcoff.U802TOCMAGIC:
+		if arch.PtrSize != 4 {
+			return errorf("32-bit XCOFF object doesn't match 64-bit %s target", arch.Name)
+		}
+	case xcoff.U64_TOCMAGIC:
+		if arch.PtrSize != 8 {
+			return errorf("64-bit XCOFF object doesn't match 32-bit %s target", arch.Name)
+		}
 	}
-	defer f.Close()
 
 	for _, sect := range f.Sections {
-		//only text, data and bss section
-		if sect.Type < xcoff.STYP_TEXT || sect.Type > xcoff.STYP_BSS {
+		// Only text, data, bss and DWARF sections.
+		if sect.Type&xcoff.STYP_DWARF == 0 && (sect.Type < xcoff.STYP_TEXT || sect.Type > xcoff.STYP_BSS) {
 			continue
 		}
 		lds := new(ldSection)
 		lds.Section = *sect
-		name := fmt.Sprintf("%s(%s)", pkg, lds.Name)
+
+		var name string
+		if lds.Type&xcoff.STYP_DWARF != 0 {
+			dw, ok := dwarfSubtypeToSym[lds.Type&^xcoff.STYP_DWARF]
+			if !ok {
+				return errorf("unrecognized DWARF subtype 0x%x for section %s", lds.Type, lds.Name)
+			}
+			name = dw.name
+		} else {
+			name = fmt.Sprintf("%s(%s)", pkg, lds.Name)
+		}
 		s := syms.Lookup(name, localSymVersion)
 
-		switch lds.Type {
-		default:
-			errorf("Unrecognize section type 0x%x", lds.Type)
-		case xcoff.STYP_TEXT:
+		switch {
+		case lds.Type&xcoff.STYP_DWARF != 0:
+			s.Type = dwarfSubtypeToSym[lds.Type&^xcoff.STYP_DWARF].kind
+		case lds.Type == xcoff.STYP_TEXT:
 			s.Type = sym.STEXT
-		case xcoff.STYP_DATA:
+		case lds.Type == xcoff.STYP_DATA:
 			s.Type = sym.SNOPTRDATA
-		case xcoff.STYP_BSS:
+		case lds.Type == xcoff.STYP_BSS:
 			s.Type = sym.SNOPTRBSS
+		default:
+			errorf("Unrecognize section type 0x%x", lds.Type)
 		}
 
 		s.Size = int64(lds.Size)
@@ -102,6 +159,29 @@ func Load(arch *sys.Arch, syms *sym.Symbols, input *bio.Reader, pkg string, leng
 
 		s := syms.Lookup(sx.Name, 0)
 
+		// Undefined external reference: leave a placeholder so relocations
+		// against this name have something to point at, without
+		// overwriting a definition already found in this or an earlier
+		// object.
+		if stype == sym.SXREF {
+			if s.Type == sym.Sxxx {
+				s.Type = sym.SXREF
+			}
+			// Only mark the reference itself as weak; don't touch a real
+			// definition that's already been loaded from elsewhere.
+			if sx.StorageClass == xcoff.C_WEAKEXT && (s.Type == sym.Sxxx || s.Type == sym.SXREF) {
+				s.Attr |= sym.AttrDuplicateOK
+			}
+			continue
+		}
+
+		// A real definition promotes a forward-declared placeholder (from
+		// an unresolved reference seen in an earlier object or archive
+		// member) to its actual type, so it's picked up below.
+		if s.Type == sym.Sxxx || s.Type == sym.SXREF {
+			s.Type = stype
+		}
+
 		// Text symbol
 		if s.Type == sym.STEXT {
 			if s.Attr.OnList() {
@@ -121,8 +201,7 @@ func Load(arch *sys.Arch, syms *sym.Symbols, input *bio.Reader, pkg string, leng
 
 	// Read relocation
 	for _, sect := range ldSections {
-		// TODO(aix): Dwarf section relocation if needed
-		if sect.Type != xcoff.STYP_TEXT && sect.Type != xcoff.STYP_DATA {
+		if sect.Type != xcoff.STYP_TEXT && sect.Type != xcoff.STYP_DATA && sect.Type&xcoff.STYP_DWARF == 0 {
 			continue
 		}
 		rs := make([]sym.Reloc, sect.Nreloc)
@@ -136,21 +215,89 @@ func Load(arch *sys.Arch, syms *sym.Symbols, input *bio.Reader, pkg string, leng
 			r.Off = int32(rx.VirtualAddress)
 			switch rx.Type {
 			default:
-				errorf("%s: section %s: unknown relocation of type 0x%x", pn, sect.Name, rx.Type)
+				return errorf("%s: section %s: unknown relocation of type 0x%x", pn, sect.Name, rx.Type)
 			case xcoff.R_POS:
 				// Reloc the address of r.Sym
-				// Length should be 64
-				if rx.Length != 64 {
-					errorf("%s: section %s: relocation R_POS has length different from 64: %d", pn, sect.Name, rx.Length)
+				// Length should be 64 on XCOFF64 and 32 on XCOFF32.
+				switch rx.Length {
+				default:
+					return errorf("%s: section %s: relocation R_POS has length different from 32 or 64: %d", pn, sect.Name, rx.Length)
+				case 64:
+					r.Siz = 8
+				case 32:
+					r.Siz = 4
 				}
-				r.Siz = 8
 				r.Type = objabi.R_CONST
 				r.Add = int64(rx.Symbol.Value)
 
-			case xcoff.R_RBR:
+			case xcoff.R_RBR, xcoff.R_BR:
+				// Self-relative branch (AA=0). The instruction word already
+				// carries the pre-relocated displacement; keep it as the
+				// addend so a branch to an external symbol (resolved later
+				// by the host linker) still lands on the right target.
+				if rx.Length != 26 {
+					return errorf("%s: section %s: branch relocation has length different from 26: %d", pn, sect.Name, rx.Length)
+				}
 				r.Siz = 4
 				r.Type = objabi.R_CALLPOWER
-				r.Add = 0 //
+				r.Add = int64(int32(branchDisplacement(sect.sym.P, r.Off)))
+
+			case xcoff.R_BA, xcoff.R_RBA:
+				// Absolute branch (AA=1): the target is an absolute address
+				// encoded in the instruction, not PC-relative, so it can't
+				// be handled by R_CALLPOWER's relative resolution. Reject it
+				// explicitly rather than silently computing the wrong target.
+				return errorf("%s: section %s: absolute branch relocation of type 0x%x is not supported", pn, sect.Name, rx.Type)
+
+			case xcoff.R_TOC, xcoff.R_TOCU, xcoff.R_TOCL:
+				// TOC-relative reference. R_TOCU/R_TOCL select the high and
+				// low 16-bit halves of a split addis/ld(addi) pair; a bare
+				// R_TOC is a single 16-bit TOC-relative field.
+				if rx.Length != 16 {
+					return errorf("%s: section %s: TOC-relative relocation has length different from 16: %d", pn, sect.Name, rx.Length)
+				}
+				r.Siz = 2
+				if rx.Type == xcoff.R_TOCL && tocrelDSForm(sect.sym.P, r.Off) {
+					// The consuming instruction is DS-form (ld/lwa/std),
+					// which reserves the low two bits of the displacement
+					// for its own opcode bits. A D-form instruction
+					// (addi/lwz/stw, ...) uses the plain 16-bit encoding.
+					r.Type = objabi.R_ADDRPOWER_TOCREL_DS
+				} else {
+					r.Type = objabi.R_ADDRPOWER_TOCREL
+				}
+				r.Add = int64(rx.Symbol.Value)
+
+			case xcoff.R_REL:
+				switch rx.Length {
+				default:
+					return errorf("%s: section %s: relocation R_REL has length different from 16, 32 or 64: %d", pn, sect.Name, rx.Length)
+				case 64:
+					r.Siz = 8
+				case 32:
+					r.Siz = 4
+				case 16:
+					r.Siz = 2
+				}
+				r.Type = objabi.R_PCREL
+				r.Add = int64(rx.Symbol.Value)
+
+			case xcoff.R_TLS, xcoff.R_TLS_IE, xcoff.R_TLSM, xcoff.R_TLSML:
+				if rx.Length != 16 {
+					return errorf("%s: section %s: TLS relocation has length different from 16: %d", pn, sect.Name, rx.Length)
+				}
+				r.Siz = 2
+				switch rx.Type {
+				case xcoff.R_TLS:
+					r.Type = objabi.R_POWER_TLS_LE
+				case xcoff.R_TLS_IE:
+					r.Type = objabi.R_POWER_TLS_IE
+				case xcoff.R_TLSM:
+					r.Type = objabi.R_POWER_TLS
+				case xcoff.R_TLSML:
+					r.Type = objabi.R_POWER_TLS_LD
+				}
+				r.Add = int64(rx.Symbol.Value)
 
 			}
 		}
@@ -162,6 +309,154 @@ func Load(arch *sys.Arch, syms *sym.Symbols, input *bio.Reader, pkg string, leng
 
 }
 
+// branchDisplacement extracts the sign-extended displacement already
+// encoded in a PowerPC branch instruction (b, bl, ba or bla) at offset off
+// in p, so it can be preserved as the relocation addend.
+func branchDisplacement(p []byte, off int32) int32 {
+	if p == nil || int(off)+4 > len(p) {
+		return 0
+	}
+	insn := binary.BigEndian.Uint32(p[off : off+4])
+	disp := int32(insn & 0x03fffffc)
+	disp <<= 6
+	disp >>= 6 // sign extend the 26-bit displacement field
+	return disp
+}
+
+// tocrelDSForm reports whether the instruction at offset off in p is a
+// DS-form load/store (ld, lwa or std), which reserves the low two bits of
+// its 16-bit displacement for its own opcode bits. Other instructions that
+// take a TOC-relative low half (addi, lwz, stw, ...) are D-form and use the
+// displacement field unconstrained.
+func tocrelDSForm(p []byte, off int32) bool {
+	if p == nil || int(off)+4 > len(p) {
+		return false
+	}
+	insn := binary.BigEndian.Uint32(p[off : off+4])
+	switch insn >> 26 {
+	case 58, 62: // ld/lwa, std
+		return true
+	}
+	return false
+}
+
+// Magic string at the start of an AIX big-format archive.
+const bigArMagic = "<bigaf>\n"
+
+// Fixed-width fields of the big-archive global header, following the magic.
+// See the "Big Archive File Format" chapter of the AIX "Files Reference".
+const (
+	bigArOffsetFieldLen = 20 // each offset field is a 20-byte ASCII decimal
+	bigArFstmOff        = len(bigArMagic) + 3*bigArOffsetFieldLen
+)
+
+// bigArMember describes a parsed member header of a big-format archive.
+type bigArMember struct {
+	name    string
+	next    int64 // file offset of the next member header, 0 if none
+	dataOff int64 // file offset of the member's data
+	size    int64 // size in bytes of the member's data
+}
+
+// bigArDecimal parses a fixed-width, blank-padded ASCII decimal field such
+// as the ones used throughout the big-archive headers.
+func bigArDecimal(b []byte) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// readBigArMember reads and parses the archive member header at offset off.
+func readBigArMember(ra io.ReaderAt, off int64) (*bigArMember, error) {
+	// ar_size(20) ar_nxtmem(20) ar_prvmem(20) ar_date(12) ar_uid(12)
+	// ar_gid(12) ar_mode(12) ar_namlen(4), followed by the member name.
+	const fixedLen = 20 + 20 + 20 + 12 + 12 + 12 + 12 + 4
+	var hdr [fixedLen]byte
+	if _, err := ra.ReadAt(hdr[:], off); err != nil {
+		return nil, fmt.Errorf("reading archive member header at %#x: %v", off, err)
+	}
+	size, err := bigArDecimal(hdr[0:20])
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive member size at %#x: %v", off, err)
+	}
+	next, err := bigArDecimal(hdr[20:40])
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive member next offset at %#x: %v", off, err)
+	}
+	namlen, err := bigArDecimal(hdr[fixedLen-4 : fixedLen])
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive member name length at %#x: %v", off, err)
+	}
+	name := make([]byte, namlen)
+	if _, err := ra.ReadAt(name, off+int64(fixedLen)); err != nil {
+		return nil, fmt.Errorf("reading archive member name at %#x: %v", off, err)
+	}
+	// Member name is followed by a "`\n" terminator, padded to an even
+	// offset, before the member's data begins.
+	dataOff := off + int64(fixedLen) + namlen
+	dataOff += dataOff % 2
+	dataOff += 2
+	return &bigArMember{name: string(name), next: next, dataOff: dataOff, size: size}, nil
+}
+
+// LoadArchive loads every XCOFF object contained in the AIX big-format
+// archive pn, read from input. It is used to pull individual members out of
+// system archives such as libc.a or libpthread.a. Symbols are written into
+// syms, and the aggregated slice of text symbols from every member is
+// returned. Each member's sections get their own local symbol version so that
+// members with identically-named sections don't collide; symbols from the
+// members' symbol tables, C_HIDEXT ones included, all share version 0.
+func LoadArchive(arch *sys.Arch, syms *sym.Symbols, input *bio.Reader, pkg string, pn string) (textp []*sym.Symbol, err error) {
+	ra := (*xcoffBiobuf)(input)
+
+	var magic [len(bigArMagic)]byte
+	if _, err := ra.ReadAt(magic[:], 0); err != nil {
+		return nil, fmt.Errorf("loadxcoff: %v: reading archive magic: %v", pn, err)
+	}
+	if string(magic[:]) != bigArMagic {
+		return nil, fmt.Errorf("loadxcoff: %v: not an AIX big-format archive", pn)
+	}
+
+	var fstmoff [bigArOffsetFieldLen]byte
+	if _, err := ra.ReadAt(fstmoff[:], bigArFstmOff); err != nil {
+		return nil, fmt.Errorf("loadxcoff: %v: reading first member offset: %v", pn, err)
+	}
+	off, err := bigArDecimal(fstmoff[:])
+	if err != nil {
+		return nil, fmt.Errorf("loadxcoff: %v: invalid first member offset: %v", pn, err)
+	}
+
+	seen := make(map[int64]bool)
+	for off != 0 {
+		if seen[off] {
+			return nil, fmt.Errorf("loadxcoff: %v: archive member chain cycles back to offset %#x", pn, off)
+		}
+		seen[off] = true
+
+		m, err := readBigArMember(ra, off)
+		if err != nil {
+			return nil, fmt.Errorf("loadxcoff: %v: %v", pn, err)
+		}
+		name := strings.TrimRight(m.name, "/") // some archives store "name/" entries
+		if name != "" {
+			memberPn := fmt.Sprintf("%s(%s)", pn, name)
+			memberPkg := fmt.Sprintf("%s(%s)", pkg, name)
+			sr := io.NewSectionReader(ra, m.dataOff, m.size)
+			f, err := xcoff.NewFile(sr)
+			if err != nil {
+				return nil, fmt.Errorf("loadxcoff: %v: %v", memberPn, err)
+			}
+			mtextp, err := loadObject(arch, syms, f, memberPkg, memberPn)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			textp = append(textp, mtextp...)
+		}
+		off = m.next
+	}
+
+	return textp, nil
+}
+
 // Convert symbol xcoff type to sym.SymKind
 // Returns nil if this shouldn't be added into syms (like .file or .dw symbols )
 func getSymbolType(f *xcoff.File, s *xcoff.Symbol) (stype sym.SymKind, err string) {
@@ -173,9 +468,13 @@ func getSymbolType(f *xcoff.File, s *xcoff.Symbol) (stype sym.SymKind, err strin
 		return sym.Sxxx, "Unrecognised StorageClass for sectionNumber = -2"
 	}
 
-	// extern symbols
-	// TODO(aix)
+	// Undefined external symbol, to be resolved against a later object,
+	// archive member or the host linker.
 	if s.SectionNumber == 0 {
+		switch s.StorageClass {
+		case xcoff.C_EXT, xcoff.C_WEAKEXT:
+			return sym.SXREF, ""
+		}
 		return sym.Sxxx, ""
 	}
 
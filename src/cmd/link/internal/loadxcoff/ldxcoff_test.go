@@ -0,0 +1,137 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loadxcoff
+
+import (
+	"bytes"
+	"cmd/internal/sys"
+	"cmd/link/internal/sym"
+	"debug/xcoff"
+	"encoding/binary"
+	"testing"
+)
+
+// buildXCOFF32Text builds the raw bytes of a minimal 32-bit XCOFF object
+// containing a single .text section and one C_EXT function symbol (with its
+// csect auxiliary entry) named name, defined in that section. Parsing it
+// through xcoff.NewFile gives the resulting section real backing data, so
+// Section.Data() works like it would on a real object file.
+func buildXCOFF32Text(t *testing.T, name string) []byte {
+	t.Helper()
+	if len(name) == 0 || len(name) > 8 {
+		t.Fatalf("buildXCOFF32Text: name %q must be 1-8 bytes", name)
+	}
+
+	const (
+		fileHdrSize = 20
+		scnHdrSize  = 40
+	)
+	text := []byte{0, 0, 0, 0} // placeholder instruction bytes
+	symptr := uint32(fileHdrSize + scnHdrSize + len(text))
+
+	buf := new(bytes.Buffer)
+	w := func(v interface{}) {
+		if err := binary.Write(buf, binary.BigEndian, v); err != nil {
+			t.Fatalf("buildXCOFF32Text: %v", err)
+		}
+	}
+
+	// File header.
+	w(uint16(xcoff.U802TOCMAGIC))
+	w(uint16(1)) // f_nscns
+	w(int32(0))  // f_timdat
+	w(symptr)    // f_symptr
+	w(int32(2))  // f_nsyms: the symbol itself plus its one aux entry
+	w(uint16(0)) // f_opthdr
+	w(uint16(0)) // f_flags
+
+	// Section header for .text.
+	var sname [8]byte
+	copy(sname[:], ".text")
+	buf.Write(sname[:])
+	w(uint32(0))                        // s_paddr
+	w(uint32(0))                        // s_vaddr
+	w(uint32(len(text)))                // s_size
+	w(uint32(fileHdrSize + scnHdrSize)) // s_scnptr
+	w(uint32(0))                        // s_relptr
+	w(uint32(0))                        // s_lnnoptr
+	w(uint16(0))                        // s_nreloc
+	w(uint16(0))                        // s_nlnno
+	w(uint32(xcoff.STYP_TEXT))          // s_flags
+
+	// .text data.
+	buf.Write(text)
+
+	// Symbol table: one C_EXT symbol plus its csect auxiliary entry.
+	var sym8 [8]byte
+	copy(sym8[:], name)
+	buf.Write(sym8[:])
+	w(uint32(0)) // n_value
+	w(int16(1))  // n_scnum (.text is section 1)
+	w(uint16(0)) // n_type
+	buf.WriteByte(byte(xcoff.C_EXT))
+	buf.WriteByte(1) // n_numaux
+
+	w(uint32(len(text))) // x_scnlen
+	w(uint32(0))         // x_parmhash
+	w(uint16(0))         // x_snhash
+	buf.WriteByte(0)     // x_smtyp
+	buf.WriteByte(byte(xcoff.XMC_PR))
+	w(uint32(0)) // x_stab
+	w(uint16(0)) // x_snstab
+
+	// Empty string table (just its 4-byte size field).
+	w(uint32(4))
+
+	return buf.Bytes()
+}
+
+// TestUnresolvedSymbolSatisfiedByLaterMember checks that a symbol first seen
+// as an unresolved external reference (as cgo .o files routinely emit for
+// calls into other archive members) is promoted to its real type, and ends
+// up in textp, once a later archive member supplies its definition.
+func TestUnresolvedSymbolSatisfiedByLaterMember(t *testing.T) {
+	arch := &sys.Arch{Name: "ppc", PtrSize: 4}
+	syms := sym.NewSymbols()
+
+	// First member: calls "bar" but doesn't define it.
+	ref := &xcoff.File{
+		FileHeader: xcoff.FileHeader{TargetMachine: xcoff.U802TOCMAGIC},
+		Symbols: []*xcoff.Symbol{
+			{Name: "bar", SectionNumber: 0, StorageClass: xcoff.C_EXT},
+		},
+	}
+	if _, err := loadObject(arch, syms, ref, "ref.o", "ref.o"); err != nil {
+		t.Fatalf("loading referencing member: %v", err)
+	}
+
+	s := syms.Lookup("bar", 0)
+	if s.Type != sym.SXREF {
+		t.Fatalf("bar: got type %v after reference, want SXREF", s.Type)
+	}
+
+	// Second member: the real definition of "bar", in a .text section with
+	// genuine backing data, so the section loop's Section.Data() call (every
+	// non-bss section goes through it) has something to read instead of
+	// dereferencing a nil reader.
+	def, err := xcoff.NewFile(bytes.NewReader(buildXCOFF32Text(t, "bar")))
+	if err != nil {
+		t.Fatalf("building synthetic XCOFF object: %v", err)
+	}
+	defer def.Close()
+
+	textp, err := loadObject(arch, syms, def, "def.o", "def.o")
+	if err != nil {
+		t.Fatalf("loading defining member: %v", err)
+	}
+
+	s = syms.Lookup("bar", 0)
+	if s.Type != sym.STEXT {
+		t.Fatalf("bar: got type %v after definition, want STEXT", s.Type)
+	}
+	if len(textp) != 1 || textp[0] != s {
+		t.Fatalf("bar: want it appended to textp once the real definition is loaded, got %v", textp)
+	}
+}